@@ -0,0 +1,134 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+	"github.com/sirupsen/logrus"
+)
+
+const queryCacheHits = "query_cache_hits"
+const queryCacheMisses = "query_cache_misses"
+
+// queryCache holds compiled, and optionally partially evaluated, queries
+// keyed by their textual form so repeated Check calls for the same
+// query skip Rego compilation. It is invalidated whenever the manager's
+// compiler is replaced (a new bundle revision is loaded) or whenever the
+// store commits any other write (e.g. a plain Data API update), since
+// partial evaluation with PartialEval may have folded data values that
+// aren't policy into the residual query.
+type queryCache struct {
+	mu      sync.RWMutex
+	entries map[string]rego.PreparedEvalQuery
+}
+
+func newQueryCache() *queryCache {
+	return &queryCache{entries: make(map[string]rego.PreparedEvalQuery)}
+}
+
+// reset drops all cached queries; it must be called whenever the
+// compiler changes so a stale compiled (or partially evaluated) query is
+// never reused against a new policy revision.
+func (c *queryCache) reset() {
+	c.mu.Lock()
+	c.entries = make(map[string]rego.PreparedEvalQuery)
+	c.mu.Unlock()
+}
+
+// preparedQuery returns a cached rego.PreparedEvalQuery for query,
+// compiling (and, if enabled, partially evaluating) it on the first
+// request seen for that query since the last cache reset.
+func (p *envoyExtAuthzGrpcServer) preparedQuery(ctx context.Context, txn storage.Transaction, query ast.Body, m metrics.Metrics) (*rego.PreparedEvalQuery, error) {
+	key := query.String()
+
+	p.queryCache.mu.RLock()
+	pq, ok := p.queryCache.entries[key]
+	p.queryCache.mu.RUnlock()
+	if ok {
+		m.Counter(queryCacheHits).Incr()
+		return &pq, nil
+	}
+
+	m.Counter(queryCacheMisses).Incr()
+
+	effectiveQuery := query
+	if p.cfg.PartialEval {
+		residual, err := p.partialQuery(ctx, txn, query)
+		if err != nil {
+			logrus.WithField("err", err).Warnf("Partial evaluation failed, falling back to the full query.")
+		} else {
+			effectiveQuery = residual
+		}
+	}
+
+	prepared, err := rego.New(
+		rego.ParsedQuery(effectiveQuery),
+		rego.Compiler(p.manager.GetCompiler()),
+		rego.Store(p.manager.Store),
+		rego.Transaction(txn),
+		rego.Runtime(p.manager.Info),
+	).PrepareForEval(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.queryCache.mu.Lock()
+	p.queryCache.entries[key] = prepared
+	p.queryCache.mu.Unlock()
+
+	return &prepared, nil
+}
+
+// registerCacheInvalidation resets the query cache whenever the store
+// commits a write. Compiler replacement (new bundle/policy) already
+// invalidates the cache via RegisterCompilerTrigger in New(); this also
+// covers plain data writes (e.g. through OPA's Data API or a data
+// plugin), which don't replace the compiler but can still change the
+// values partialQuery folded into a cached residual query.
+func (p *envoyExtAuthzGrpcServer) registerCacheInvalidation(ctx context.Context) error {
+	return storage.Txn(ctx, p.manager.Store, storage.WriteParams, func(txn storage.Transaction) error {
+		_, err := p.manager.Store.Register(ctx, txn, storage.TriggerConfig{
+			OnCommit: func(context.Context, storage.Transaction, storage.TriggerEvent) {
+				p.queryCache.reset()
+			},
+		})
+		return err
+	})
+}
+
+// partialQuery partially evaluates query treating "input" as unknown,
+// producing a residual query with as much of the policy's data-only
+// logic folded away as possible. Per-request evaluation of the residual
+// is cheaper than evaluating the original query from scratch. Note that
+// this only marks "input" unknown, so data references are folded at
+// prepare time; registerCacheInvalidation is what keeps that safe by
+// dropping the cache on any data write rather than trying to track which
+// data roots a given residual actually depends on.
+func (p *envoyExtAuthzGrpcServer) partialQuery(ctx context.Context, txn storage.Transaction, query ast.Body) (ast.Body, error) {
+	pq, err := rego.New(
+		rego.ParsedQuery(query),
+		rego.Compiler(p.manager.GetCompiler()),
+		rego.Store(p.manager.Store),
+		rego.Transaction(txn),
+		rego.Runtime(p.manager.Info),
+		rego.Unknowns([]string{"input"}),
+	).Partial(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("partial evaluation: %w", err)
+	}
+
+	if len(pq.Queries) != 1 {
+		return nil, fmt.Errorf("partial evaluation produced %d residual queries, expected exactly 1", len(pq.Queries))
+	}
+
+	return pq.Queries[0], nil
+}
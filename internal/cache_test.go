@@ -0,0 +1,50 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/rego"
+)
+
+func TestQueryCacheReset(t *testing.T) {
+	c := newQueryCache()
+	c.entries["data.istio.authz.allow"] = rego.PreparedEvalQuery{}
+
+	if len(c.entries) != 1 {
+		t.Fatalf("expected 1 cached entry, got %d", len(c.entries))
+	}
+
+	c.reset()
+
+	if len(c.entries) != 0 {
+		t.Fatalf("expected reset to clear all entries, got %d", len(c.entries))
+	}
+}
+
+// BenchmarkQueryCacheHit exercises the cache hit path preparedQuery
+// takes on every Check call once a query has been compiled, i.e. the
+// lookup this series introduced to avoid recompiling the Rego query (or
+// its routing rule) on every request. It doesn't exercise a real Rego
+// Eval, since that needs a live plugins.Manager/compiler; see chunk0-6's
+// commit for why that's out of scope here.
+func BenchmarkQueryCacheHit(b *testing.B) {
+	c := newQueryCache()
+	const key = "data.istio.authz.allow"
+	c.entries[key] = rego.PreparedEvalQuery{}
+
+	b.ReportAllocs()
+	b.ResetTimer()
+
+	for i := 0; i < b.N; i++ {
+		c.mu.RLock()
+		_, ok := c.entries[key]
+		c.mu.RUnlock()
+		if !ok {
+			b.Fatal("expected cache hit")
+		}
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/json"
+	"strconv"
+
+	envoy_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/service/auth/v2"
+	envoy_type "github.com/envoyproxy/go-control-plane/envoy/type"
+	google_rpc "github.com/gogo/googleapis/google/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// structuredDecision is the shape Rego may return instead of a plain
+// boolean, letting policy drive the response status, headers and body
+// returned to Envoy.
+type structuredDecision struct {
+	Allowed              bool              `json:"allowed"`
+	HTTPStatus           int32             `json:"http_status"`
+	Headers              map[string]string `json:"headers"`
+	Body                 string            `json:"body"`
+	ResponseHeadersToAdd map[string]string `json:"response_headers_to_add"`
+}
+
+// parseDecision interprets the raw JSON decision produced by eval. A
+// JSON object is treated as a structuredDecision; anything else (a bare
+// boolean, for backward compatibility) falls back to the legacy
+// allow/deny behavior.
+func parseDecision(raw string) (allowed bool, structured *structuredDecision) {
+	var obj map[string]interface{}
+	if err := json.Unmarshal([]byte(raw), &obj); err == nil && obj != nil {
+		var sd structuredDecision
+		if err := json.Unmarshal([]byte(raw), &sd); err != nil {
+			logrus.WithFields(logrus.Fields{
+				"decision": raw,
+				"err":      err,
+			}).Warnf("Decision looked like a structured decision object but didn't match its shape; denying.")
+			return false, nil
+		}
+		return sd.Allowed, &sd
+	}
+
+	allowed, err := strconv.ParseBool(raw)
+	if err != nil {
+		logrus.WithFields(logrus.Fields{
+			"decision": raw,
+			"err":      err,
+		}).Warnf("Decision was neither a structured decision object nor a boolean; denying.")
+	}
+	return allowed, nil
+}
+
+// buildCheckResponse translates a decision into the gRPC CheckResponse
+// Envoy expects, populating OkHttpResponse/DeniedHttpResponse from the
+// structured decision when one was returned.
+func buildCheckResponse(allowed bool, sd *structuredDecision, dryRun bool) *ext_authz.CheckResponse {
+	status := int32(google_rpc.PERMISSION_DENIED)
+	if dryRun || allowed {
+		status = int32(google_rpc.OK)
+	}
+
+	resp := &ext_authz.CheckResponse{
+		Status: &google_rpc.Status{Code: status},
+	}
+
+	if sd == nil {
+		return resp
+	}
+
+	if status == int32(google_rpc.OK) {
+		resp.HttpResponse = &ext_authz.CheckResponse_OkResponse{
+			OkResponse: &ext_authz.OkHttpResponse{
+				Headers: toHeaderValueOptions(sd.ResponseHeadersToAdd),
+			},
+		}
+		return resp
+	}
+
+	denied := &ext_authz.DeniedHttpResponse{
+		Body:    sd.Body,
+		Headers: toHeaderValueOptions(sd.Headers),
+	}
+	if sd.HTTPStatus != 0 {
+		denied.Status = &envoy_type.HttpStatus{Code: envoy_type.StatusCode(sd.HTTPStatus)}
+	}
+	resp.HttpResponse = &ext_authz.CheckResponse_DeniedResponse{DeniedResponse: denied}
+
+	return resp
+}
+
+func toHeaderValueOptions(headers map[string]string) []*envoy_core.HeaderValueOption {
+	if len(headers) == 0 {
+		return nil
+	}
+
+	opts := make([]*envoy_core.HeaderValueOption, 0, len(headers))
+	for k, v := range headers {
+		opts = append(opts, &envoy_core.HeaderValueOption{
+			Header: &envoy_core.HeaderValue{Key: k, Value: v},
+		})
+	}
+
+	return opts
+}
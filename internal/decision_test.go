@@ -0,0 +1,62 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import "testing"
+
+func TestParseDecision(t *testing.T) {
+	cases := []struct {
+		name            string
+		raw             string
+		wantAllowed     bool
+		wantStructured  bool
+		wantStructField bool // when wantStructured, assert Allowed came through
+	}{
+		{name: "bare true", raw: "true", wantAllowed: true},
+		{name: "bare false", raw: "false", wantAllowed: false},
+		{name: "malformed scalar", raw: `"nope"`, wantAllowed: false},
+		{
+			name:            "structured allow",
+			raw:             `{"allowed":true,"http_status":200}`,
+			wantAllowed:     true,
+			wantStructured:  true,
+			wantStructField: true,
+		},
+		{
+			name:           "structured deny with headers",
+			raw:            `{"allowed":false,"http_status":403,"body":"nope","headers":{"x-foo":"bar"}}`,
+			wantAllowed:    false,
+			wantStructured: true,
+		},
+		{
+			// http_status should be a number; a string value makes the
+			// whole object fail to unmarshal into structuredDecision.
+			name:           "malformed structured decision falls back to deny",
+			raw:            `{"allowed":true,"http_status":"403"}`,
+			wantAllowed:    false,
+			wantStructured: false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			allowed, structured := parseDecision(c.raw)
+
+			if allowed != c.wantAllowed {
+				t.Errorf("allowed = %v, want %v", allowed, c.wantAllowed)
+			}
+
+			if c.wantStructured && structured == nil {
+				t.Errorf("expected a structured decision, got nil")
+			}
+			if !c.wantStructured && structured != nil {
+				t.Errorf("expected no structured decision, got %+v", structured)
+			}
+			if c.wantStructField && structured != nil && structured.Allowed != c.wantAllowed {
+				t.Errorf("structured.Allowed = %v, want %v", structured.Allowed, c.wantAllowed)
+			}
+		})
+	}
+}
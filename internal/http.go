@@ -0,0 +1,146 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"net/http"
+
+	envoy_core "github.com/envoyproxy/go-control-plane/envoy/api/v2/core"
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/service/auth/v2"
+	google_rpc "github.com/gogo/googleapis/google/rpc"
+	"github.com/sirupsen/logrus"
+)
+
+// httpServer adapts Envoy's ext_authz HTTP check protocol onto the
+// plugin's shared Check pipeline, so the gRPC and HTTP transports always
+// reach the same authorization decision.
+type httpServer struct {
+	plugin *envoyExtAuthzGrpcServer
+	server *http.Server
+}
+
+func newHTTPServer(p *envoyExtAuthzGrpcServer, addr string) *httpServer {
+	h := &httpServer{plugin: p}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", h.handle)
+	h.server = &http.Server{Addr: addr, Handler: mux}
+	return h
+}
+
+func (h *httpServer) listen() {
+	logrus.WithField("addr", h.server.Addr).Infof("Starting HTTP server.")
+
+	if err := h.server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		logrus.WithField("err", err).Fatalf("HTTP listener failed.")
+	}
+
+	logrus.Info("HTTP listener exited.")
+}
+
+func (h *httpServer) stop(ctx context.Context) {
+	if err := h.server.Shutdown(ctx); err != nil {
+		logrus.WithField("err", err).Warnf("Error shutting down HTTP server.")
+	}
+}
+
+func (h *httpServer) handle(w http.ResponseWriter, r *http.Request) {
+	req, err := checkRequestFromHTTP(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	resp, err := h.plugin.Check(r.Context(), req)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	writeHTTPResponse(w, resp)
+}
+
+// checkRequestFromHTTP builds an ext_authz.CheckRequest out of the
+// incoming HTTP request, mirroring what Envoy's ext_authz HTTP filter
+// forwards to a raw_http check server: method, path, headers, and
+// (optionally) the request body. The body is base64-encoded into
+// Http.Body to match what Envoy's gRPC transport sends and what
+// decodeBodyBase64Transform expects, so the shared Check pipeline sees
+// the same encoding regardless of transport.
+func checkRequestFromHTTP(r *http.Request) (*ext_authz.CheckRequest, error) {
+	headers := make(map[string]string, len(r.Header))
+	for k := range r.Header {
+		headers[k] = r.Header.Get(k)
+	}
+
+	var body string
+	if r.Body != nil {
+		bs, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return nil, err
+		}
+		body = base64.StdEncoding.EncodeToString(bs)
+	}
+
+	return &ext_authz.CheckRequest{
+		Attributes: &ext_authz.AttributeContext{
+			Request: &ext_authz.AttributeContext_Request{
+				Http: &ext_authz.AttributeContext_HttpRequest{
+					Method:  r.Method,
+					Path:    r.URL.RequestURI(),
+					Host:    r.Host,
+					Headers: headers,
+					Body:    body,
+				},
+			},
+		},
+	}, nil
+}
+
+// writeHTTPResponse translates a Check decision into the HTTP status
+// code, headers and body Envoy's HTTP ext_authz filter expects back
+// from the check server, pulling those from the structured decision
+// object when the policy returned one.
+func writeHTTPResponse(w http.ResponseWriter, resp *ext_authz.CheckResponse) {
+	allowed := resp.Status.Code == int32(google_rpc.OK)
+
+	status := http.StatusOK
+	if !allowed {
+		status = http.StatusForbidden
+		if resp.Status.Code == int32(google_rpc.UNAUTHENTICATED) {
+			status = http.StatusUnauthorized
+		}
+	}
+
+	var body string
+	var headers []*envoy_core.HeaderValueOption
+
+	switch r := resp.HttpResponse.(type) {
+	case *ext_authz.CheckResponse_OkResponse:
+		headers = r.OkResponse.GetHeaders()
+	case *ext_authz.CheckResponse_DeniedResponse:
+		if code := r.DeniedResponse.GetStatus().GetCode(); code != 0 {
+			status = int(code)
+		}
+		body = r.DeniedResponse.GetBody()
+		headers = r.DeniedResponse.GetHeaders()
+	default:
+		if !allowed {
+			body = resp.Status.Message
+		}
+	}
+
+	for _, h := range headers {
+		w.Header().Set(h.GetHeader().GetKey(), h.GetHeader().GetValue())
+	}
+
+	w.WriteHeader(status)
+	if body != "" {
+		io.WriteString(w, body)
+	}
+}
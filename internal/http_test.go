@@ -0,0 +1,100 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/base64"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/service/auth/v2"
+	google_rpc "github.com/gogo/googleapis/google/rpc"
+)
+
+func TestCheckRequestFromHTTP(t *testing.T) {
+	r := httptest.NewRequest("POST", "http://a.example.com/foo?bar=baz", strings.NewReader("hello"))
+	r.Header.Set("Authorization", "Bearer abc")
+
+	req, err := checkRequestFromHTTP(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	http := req.GetAttributes().GetRequest().GetHttp()
+	if http.Method != "POST" {
+		t.Errorf("Method = %q, want %q", http.Method, "POST")
+	}
+	if http.Host != "a.example.com" {
+		t.Errorf("Host = %q, want %q", http.Host, "a.example.com")
+	}
+	if http.Path != "/foo?bar=baz" {
+		t.Errorf("Path = %q, want %q", http.Path, "/foo?bar=baz")
+	}
+	if http.Headers["Authorization"] != "Bearer abc" {
+		t.Errorf("Headers[Authorization] = %q, want %q", http.Headers["Authorization"], "Bearer abc")
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(http.Body)
+	if err != nil {
+		t.Fatalf("Body is not valid base64: %v", err)
+	}
+	if string(decoded) != "hello" {
+		t.Errorf("decoded body = %q, want %q", decoded, "hello")
+	}
+}
+
+func TestCheckRequestFromHTTPNoBody(t *testing.T) {
+	r := httptest.NewRequest("GET", "http://a.example.com/foo", nil)
+
+	req, err := checkRequestFromHTTP(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if body := req.GetAttributes().GetRequest().GetHttp().GetBody(); body != "" {
+		t.Errorf("Body = %q, want empty", body)
+	}
+}
+
+func TestWriteHTTPResponseAllowed(t *testing.T) {
+	resp := &ext_authz.CheckResponse{Status: &google_rpc.Status{Code: int32(google_rpc.OK)}}
+
+	w := httptest.NewRecorder()
+	writeHTTPResponse(w, resp)
+
+	if w.Code != 200 {
+		t.Errorf("status = %d, want 200", w.Code)
+	}
+}
+
+func TestWriteHTTPResponseDenied(t *testing.T) {
+	allowed, structured := parseDecision(`{"allowed":false,"http_status":403,"body":"nope","headers":{"x-reason":"denied"}}`)
+	resp := buildCheckResponse(allowed, structured, false)
+
+	w := httptest.NewRecorder()
+	writeHTTPResponse(w, resp)
+
+	if w.Code != 403 {
+		t.Errorf("status = %d, want 403", w.Code)
+	}
+	if w.Body.String() != "nope" {
+		t.Errorf("body = %q, want %q", w.Body.String(), "nope")
+	}
+	if got := w.Header().Get("x-reason"); got != "denied" {
+		t.Errorf("header x-reason = %q, want %q", got, "denied")
+	}
+}
+
+func TestWriteHTTPResponseUnauthenticated(t *testing.T) {
+	resp := &ext_authz.CheckResponse{Status: &google_rpc.Status{Code: int32(google_rpc.UNAUTHENTICATED)}}
+
+	w := httptest.NewRecorder()
+	writeHTTPResponse(w, resp)
+
+	if w.Code != 401 {
+		t.Errorf("status = %d, want 401", w.Code)
+	}
+}
@@ -11,7 +11,6 @@ import (
 	"fmt"
 	"io"
 	"net"
-	"strconv"
 	"strings"
 	"time"
 
@@ -51,9 +50,11 @@ type evalResult struct {
 func Validate(m *plugins.Manager, bs []byte) (*Config, error) {
 
 	cfg := Config{
-		Addr:   defaultAddr,
-		Query:  defaultQuery,
-		DryRun: defaultDryRun,
+		Addr:        defaultAddr,
+		Query:       defaultQuery,
+		DryRun:      defaultDryRun,
+		TokenHeader: defaultTokenHeader,
+		RouteQuery:  defaultRouteQuery,
 	}
 
 	if err := util.Unmarshal(bs, &cfg); err != nil {
@@ -66,6 +67,31 @@ func Validate(m *plugins.Manager, bs []byte) (*Config, error) {
 	}
 	cfg.parsedQuery = parsedQuery
 
+	for i, route := range cfg.Routes {
+		parsedRouteQuery, err := ast.ParseBody(route.Query)
+		if err != nil {
+			return nil, fmt.Errorf("route %d: %w", i, err)
+		}
+		cfg.Routes[i].parsedQuery = parsedRouteQuery
+	}
+
+	if cfg.RouteQuery != "" {
+		routeParsedQuery, err := ast.ParseBody(cfg.RouteQuery)
+		if err != nil {
+			return nil, fmt.Errorf("route-query: %w", err)
+		}
+		cfg.routeParsedQuery = routeParsedQuery
+	}
+
+	cfg.jwksRefreshInterval = defaultJWKSRefreshInterval
+	if cfg.JWKSRefreshInterval != "" {
+		d, err := time.ParseDuration(cfg.JWKSRefreshInterval)
+		if err != nil {
+			return nil, fmt.Errorf("invalid jwks-refresh-interval: %w", err)
+		}
+		cfg.jwksRefreshInterval = d
+	}
+
 	return &cfg, nil
 }
 
@@ -73,9 +99,22 @@ func Validate(m *plugins.Manager, bs []byte) (*Config, error) {
 func New(m *plugins.Manager, cfg *Config) plugins.Plugin {
 
 	plugin := &envoyExtAuthzGrpcServer{
-		manager: m,
-		cfg:     *cfg,
-		server:  grpc.NewServer(),
+		manager:    m,
+		cfg:        *cfg,
+		server:     grpc.NewServer(),
+		queryCache: newQueryCache(),
+	}
+
+	m.RegisterCompilerTrigger(func(storage.Transaction) {
+		plugin.queryCache.reset()
+	})
+
+	if len(cfg.Issuers) > 0 {
+		plugin.jwtVerifier = newJWTVerifier(cfg.Issuers, cfg.jwksRefreshInterval)
+	}
+
+	if cfg.HTTPAddr != "" {
+		plugin.httpServer = newHTTPServer(plugin, cfg.HTTPAddr)
 	}
 
 	ext_authz.RegisterAuthorizationServer(plugin.server, plugin)
@@ -85,25 +124,54 @@ func New(m *plugins.Manager, cfg *Config) plugins.Plugin {
 
 // Config represents the plugin configuration.
 type Config struct {
-	Addr        string `json:"addr"`
-	Query       string `json:"query"`
-	DryRun      bool   `json:"dry-run"`
-	parsedQuery ast.Body
+	Addr                string         `json:"addr"`
+	HTTPAddr            string         `json:"http-addr"`
+	Query               string         `json:"query"`
+	DryRun              bool           `json:"dry-run"`
+	TokenHeader         string         `json:"token-header"`
+	Issuers             []IssuerConfig `json:"issuers"`
+	JWKSRefreshInterval string         `json:"jwks-refresh-interval"`
+	InputTransforms     []string       `json:"input-transforms"`
+	MaxBodyBytes        int64          `json:"max-body-bytes"`
+	Routes              []RouteConfig  `json:"routes"`
+	RouteQuery          string         `json:"route-query"`
+	PartialEval         bool           `json:"partial-eval"`
+	TokenExemptPaths    []PathMatch    `json:"token-exempt-paths"`
+	parsedQuery         ast.Body
+	jwksRefreshInterval time.Duration
+	routeParsedQuery    ast.Body
 }
 
 type envoyExtAuthzGrpcServer struct {
-	cfg     Config
-	server  *grpc.Server
-	manager *plugins.Manager
+	cfg         Config
+	server      *grpc.Server
+	httpServer  *httpServer
+	manager     *plugins.Manager
+	jwtVerifier *jwtVerifier
+	queryCache  *queryCache
 }
 
 func (p *envoyExtAuthzGrpcServer) Start(ctx context.Context) error {
-	go p.listen()
+	if err := p.registerCacheInvalidation(ctx); err != nil {
+		return err
+	}
+	if p.jwtVerifier != nil {
+		p.jwtVerifier.Start(ctx)
+	}
+	if p.cfg.Addr != "" {
+		go p.listen()
+	}
+	if p.httpServer != nil {
+		go p.httpServer.listen()
+	}
 	return nil
 }
 
 func (p *envoyExtAuthzGrpcServer) Stop(ctx context.Context) {
 	p.server.Stop()
+	if p.httpServer != nil {
+		p.httpServer.stop(ctx)
+	}
 }
 
 func (p *envoyExtAuthzGrpcServer) Reconfigure(ctx context.Context, config interface{}) {
@@ -148,27 +216,42 @@ func (p *envoyExtAuthzGrpcServer) Check(ctx ctx.Context, req *ext_authz.CheckReq
 
 	input["parsed_path"] = getParsedPath(req)
 
+	if err := p.applyTransforms(input, req); err != nil {
+		logrus.WithField("err", err).Warnf("Input transform failed.")
+	}
+
+	host := req.GetAttributes().GetRequest().GetHttp().GetHost()
+	path := req.GetAttributes().GetRequest().GetHttp().GetPath()
+
+	if p.jwtVerifier != nil && p.tokenRequired(host, path) {
+		claims, verifyErr := p.verifyToken(req)
+		if verifyErr != nil {
+			logrus.WithField("err", verifyErr).Debugf("Token verification failed.")
+			if !p.cfg.DryRun {
+				return &ext_authz.CheckResponse{
+					Status: &google_rpc.Status{
+						Code:    int32(google_rpc.UNAUTHENTICATED),
+						Message: verifyErr.Error(),
+					},
+				}, nil
+			}
+		} else {
+			input["parsed_token"] = claims
+		}
+	}
+
 	inputValue, err := ast.InterfaceToValue(input)
 	if err != nil {
 		return nil, err
 	}
 
-	result, err := p.eval(ctx, inputValue)
+	result, err := p.eval(ctx, inputValue, host, path)
 	if err != nil {
 		return nil, err
 	}
 
-	status := int32(google_rpc.PERMISSION_DENIED)
-
-	var allow bool
-	allow, _ = strconv.ParseBool(result.decision)
-	if p.cfg.DryRun || allow {
-		status = int32(google_rpc.OK)
-	}
-
-	resp := &ext_authz.CheckResponse{
-		Status: &google_rpc.Status{Code: status},
-	}
+	allowed, structuredDecision := parseDecision(result.decision)
+	resp := buildCheckResponse(allowed, structuredDecision, p.cfg.DryRun)
 
 	err = p.log(ctx, input, result, err)
 	if err != nil {
@@ -194,7 +277,7 @@ func (p *envoyExtAuthzGrpcServer) Check(ctx ctx.Context, req *ext_authz.CheckReq
 	return resp, nil
 }
 
-func (p *envoyExtAuthzGrpcServer) eval(ctx context.Context, input ast.Value, opts ...func(*rego.Rego)) (*evalResult, error) {
+func (p *envoyExtAuthzGrpcServer) eval(ctx context.Context, input ast.Value, host, path string) (*evalResult, error) {
 	result := &evalResult{}
 	result.metrics = metrics.New()
 
@@ -223,16 +306,21 @@ func (p *envoyExtAuthzGrpcServer) eval(ctx context.Context, input ast.Value, opt
 			"txn":     result.txnID,
 		}).Debugf("Executing policy query.")
 
-		opts = append(opts,
-			rego.Metrics(result.metrics),
-			rego.ParsedQuery(p.cfg.parsedQuery),
-			rego.ParsedInput(input),
-			rego.Compiler(p.manager.GetCompiler()),
-			rego.Store(p.manager.Store),
-			rego.Transaction(txn),
-			rego.Runtime(p.manager.Info))
+		query, err := p.resolveQuery(ctx, txn, input, host, path, result.metrics)
+		if err != nil {
+			return err
+		}
+
+		pq, err := p.preparedQuery(ctx, txn, query, result.metrics)
+		if err != nil {
+			return err
+		}
 
-		rs, err := rego.New(opts...).Eval(ctx)
+		rs, err := pq.Eval(ctx,
+			rego.EvalParsedInput(input),
+			rego.EvalTransaction(txn),
+			rego.EvalMetrics(result.metrics),
+		)
 
 		// In "dry-run" mode, we just log all failure conditions
 		// even ones that would typically be considered an error
@@ -317,6 +405,19 @@ func getRevision(ctx context.Context, store storage.Store, txn storage.Transacti
 	return revision, nil
 }
 
+// verifyToken extracts the bearer token from the configured header and
+// verifies it against the plugin's trusted issuers, returning its claims.
+func (p *envoyExtAuthzGrpcServer) verifyToken(req *ext_authz.CheckRequest) (map[string]interface{}, error) {
+	headers := req.GetAttributes().GetRequest().GetHttp().GetHeaders()
+
+	rawToken, ok := extractBearerToken(headers, p.cfg.TokenHeader)
+	if !ok {
+		return nil, fmt.Errorf("no token found in %q header", p.cfg.TokenHeader)
+	}
+
+	return p.jwtVerifier.Verify(rawToken)
+}
+
 func getParsedPath(req *ext_authz.CheckRequest) []interface{} {
 	path := req.GetAttributes().GetRequest().GetHttp().GetPath()
 	parsedPath := strings.Split(strings.TrimLeft(path, "/"), "/")
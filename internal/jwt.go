@@ -0,0 +1,233 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+const defaultTokenHeader = "authorization"
+const defaultJWKSRefreshInterval = 5 * time.Minute
+
+// IssuerConfig describes a trusted token issuer: where to fetch its
+// signing keys from and what claims tokens from it must satisfy.
+type IssuerConfig struct {
+	Issuer   string `json:"issuer"`
+	JWKSURL  string `json:"jwks-url"`
+	Audience string `json:"audience"`
+}
+
+// jwtVerifier verifies bearer tokens against a set of trusted issuers,
+// keeping each issuer's JWKS cached and periodically refreshed.
+type jwtVerifier struct {
+	refreshInterval time.Duration
+
+	mu      sync.RWMutex
+	issuers map[string]*issuerKeySet
+}
+
+type issuerKeySet struct {
+	cfg IssuerConfig
+
+	mu        sync.RWMutex
+	keySet    jose.JSONWebKeySet
+	fetchedAt time.Time
+}
+
+func newJWTVerifier(issuers []IssuerConfig, refreshInterval time.Duration) *jwtVerifier {
+	if refreshInterval <= 0 {
+		refreshInterval = defaultJWKSRefreshInterval
+	}
+
+	v := &jwtVerifier{
+		refreshInterval: refreshInterval,
+		issuers:         make(map[string]*issuerKeySet, len(issuers)),
+	}
+
+	for _, cfg := range issuers {
+		v.issuers[cfg.Issuer] = &issuerKeySet{cfg: cfg}
+	}
+
+	return v
+}
+
+// Start refreshes each issuer's JWKS in the background every
+// v.refreshInterval (plus an immediate first fetch) until ctx is
+// cancelled. It never blocks the caller: the initial fetch runs in the
+// same background goroutine as the ticker loop, so a slow or
+// unreachable JWKS URL can't hang plugin startup.
+func (v *jwtVerifier) Start(ctx context.Context) {
+	go func() {
+		v.refreshAll(ctx)
+
+		ticker := time.NewTicker(v.refreshInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				v.refreshAll(ctx)
+			}
+		}
+	}()
+}
+
+// refreshAll refreshes every issuer's JWKS concurrently, so one slow or
+// unreachable issuer can't delay the others.
+func (v *jwtVerifier) refreshAll(ctx context.Context) {
+	var wg sync.WaitGroup
+	for _, ks := range v.issuers {
+		wg.Add(1)
+		go func(ks *issuerKeySet) {
+			defer wg.Done()
+			if err := ks.refresh(ctx); err != nil {
+				logrus.WithField("err", err).Warnf("Unable to refresh JWKS for issuer %q.", ks.cfg.Issuer)
+			}
+		}(ks)
+	}
+	wg.Wait()
+}
+
+// Verify parses and validates rawToken, returning its claims as a plain
+// map suitable for use as Rego input. The token's "iss" claim selects
+// which issuer's keys (and audience requirement) to validate against.
+func (v *jwtVerifier) Verify(rawToken string) (map[string]interface{}, error) {
+	tok, err := jwt.ParseSigned(rawToken)
+	if err != nil {
+		return nil, fmt.Errorf("parse token: %w", err)
+	}
+
+	var unverified map[string]interface{}
+	if err := tok.UnsafeClaimsWithoutVerification(&unverified); err != nil {
+		return nil, fmt.Errorf("decode claims: %w", err)
+	}
+
+	iss, _ := unverified["iss"].(string)
+
+	v.mu.RLock()
+	ks, ok := v.issuers[iss]
+	v.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("untrusted issuer: %q", iss)
+	}
+
+	claims, err := ks.verify(tok)
+	if err != nil {
+		return nil, err
+	}
+
+	return claims, nil
+}
+
+func (ks *issuerKeySet) refresh(ctx context.Context) error {
+	keySet, err := fetchJWKS(ctx, ks.cfg.JWKSURL)
+	if err != nil {
+		return fmt.Errorf("refresh jwks for issuer %q: %w", ks.cfg.Issuer, err)
+	}
+
+	ks.mu.Lock()
+	ks.keySet = keySet
+	ks.fetchedAt = time.Now()
+	ks.mu.Unlock()
+
+	return nil
+}
+
+func (ks *issuerKeySet) verify(tok *jwt.JSONWebToken) (map[string]interface{}, error) {
+	ks.mu.RLock()
+	keySet := ks.keySet
+	ks.mu.RUnlock()
+
+	var lastErr error
+	for _, key := range keySet.Keys {
+		var claims jwt.Claims
+		var raw map[string]interface{}
+		if err := tok.Claims(key.Key, &claims, &raw); err != nil {
+			lastErr = err
+			continue
+		}
+
+		expected := jwt.Expected{
+			Issuer: ks.cfg.Issuer,
+			Time:   time.Now(),
+		}
+		if ks.cfg.Audience != "" {
+			expected.Audience = jwt.Audience{ks.cfg.Audience}
+		}
+
+		if err := claims.Validate(expected); err != nil {
+			return nil, fmt.Errorf("validate claims: %w", err)
+		}
+
+		return raw, nil
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("no signing key matched token for issuer %q", ks.cfg.Issuer)
+	}
+
+	return nil, lastErr
+}
+
+// jwksHTTPClient bounds how long a single JWKS fetch may take, so one
+// slow or unreachable issuer can't stall a refresh cycle indefinitely.
+var jwksHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+func fetchJWKS(ctx context.Context, url string) (jose.JSONWebKeySet, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+
+	resp, err := jwksHTTPClient.Do(req)
+	if err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return jose.JSONWebKeySet{}, fmt.Errorf("unexpected status %d fetching %s", resp.StatusCode, url)
+	}
+
+	var keySet jose.JSONWebKeySet
+	if err := json.NewDecoder(resp.Body).Decode(&keySet); err != nil {
+		return jose.JSONWebKeySet{}, err
+	}
+
+	return keySet, nil
+}
+
+// extractBearerToken pulls the raw token out of the configured header,
+// stripping a leading "Bearer " scheme if present.
+func extractBearerToken(headers map[string]string, header string) (string, bool) {
+	var value string
+	for k, v := range headers {
+		if strings.EqualFold(k, header) {
+			value = v
+			break
+		}
+	}
+
+	if value == "" {
+		return "", false
+	}
+
+	if parts := strings.SplitN(value, " ", 2); len(parts) == 2 && strings.EqualFold(parts[0], "Bearer") {
+		return parts[1], true
+	}
+
+	return value, true
+}
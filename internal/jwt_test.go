@@ -0,0 +1,175 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+	"time"
+
+	jose "gopkg.in/square/go-jose.v2"
+	"gopkg.in/square/go-jose.v2/jwt"
+)
+
+func TestExtractBearerToken(t *testing.T) {
+	cases := []struct {
+		name      string
+		headers   map[string]string
+		header    string
+		wantToken string
+		wantOK    bool
+	}{
+		{
+			name:      "bearer scheme",
+			headers:   map[string]string{"Authorization": "Bearer abc.def.ghi"},
+			header:    "authorization",
+			wantToken: "abc.def.ghi",
+			wantOK:    true,
+		},
+		{
+			name:      "header name is case-insensitive",
+			headers:   map[string]string{"AUTHORIZATION": "Bearer abc"},
+			header:    "authorization",
+			wantToken: "abc",
+			wantOK:    true,
+		},
+		{
+			name:      "no scheme prefix",
+			headers:   map[string]string{"authorization": "abc.def.ghi"},
+			header:    "authorization",
+			wantToken: "abc.def.ghi",
+			wantOK:    true,
+		},
+		{
+			name:    "header absent",
+			headers: map[string]string{"x-other": "abc"},
+			header:  "authorization",
+			wantOK:  false,
+		},
+		{
+			name:    "custom header",
+			headers: map[string]string{"x-auth-token": "Bearer xyz"},
+			header:  "x-auth-token",
+			wantOK:  true,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			token, ok := extractBearerToken(c.headers, c.header)
+			if ok != c.wantOK {
+				t.Fatalf("ok = %v, want %v", ok, c.wantOK)
+			}
+			if ok && c.wantToken != "" && token != c.wantToken {
+				t.Errorf("token = %q, want %q", token, c.wantToken)
+			}
+		})
+	}
+}
+
+// signedTestToken builds a signed JWT for issuer/audience with the given
+// expiry, plus the JWKS needed to verify it.
+func signedTestToken(t *testing.T, issuer, audience string, expiry time.Time) (string, jose.JSONWebKeySet) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key: %v", err)
+	}
+
+	signer, err := jose.NewSigner(jose.SigningKey{Algorithm: jose.RS256, Key: key}, nil)
+	if err != nil {
+		t.Fatalf("new signer: %v", err)
+	}
+
+	claims := jwt.Claims{
+		Issuer:   issuer,
+		Subject:  "test-subject",
+		Audience: jwt.Audience{audience},
+		Expiry:   jwt.NewNumericDate(expiry),
+	}
+
+	raw, err := jwt.Signed(signer).Claims(claims).CompactSerialize()
+	if err != nil {
+		t.Fatalf("sign token: %v", err)
+	}
+
+	keySet := jose.JSONWebKeySet{
+		Keys: []jose.JSONWebKey{
+			{Key: key.Public(), KeyID: "", Algorithm: string(jose.RS256), Use: "sig"},
+		},
+	}
+
+	return raw, keySet
+}
+
+func verifierForIssuer(issuer, audience string, keySet jose.JSONWebKeySet) *jwtVerifier {
+	v := newJWTVerifier([]IssuerConfig{{Issuer: issuer, Audience: audience}}, time.Minute)
+	v.issuers[issuer].keySet = keySet
+	return v
+}
+
+func TestJWTVerifierVerify(t *testing.T) {
+	const issuer = "https://issuer.example.com"
+	const audience = "my-service"
+
+	t.Run("valid token", func(t *testing.T) {
+		raw, keySet := signedTestToken(t, issuer, audience, time.Now().Add(time.Hour))
+		v := verifierForIssuer(issuer, audience, keySet)
+
+		claims, err := v.Verify(raw)
+		if err != nil {
+			t.Fatalf("Verify() error = %v", err)
+		}
+		if claims["iss"] != issuer {
+			t.Errorf("claims[iss] = %v, want %v", claims["iss"], issuer)
+		}
+	})
+
+	t.Run("expired token", func(t *testing.T) {
+		raw, keySet := signedTestToken(t, issuer, audience, time.Now().Add(-time.Hour))
+		v := verifierForIssuer(issuer, audience, keySet)
+
+		if _, err := v.Verify(raw); err == nil {
+			t.Fatal("expected an error for an expired token, got nil")
+		}
+	})
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		raw, keySet := signedTestToken(t, "https://someone-else.example.com", audience, time.Now().Add(time.Hour))
+		v := verifierForIssuer(issuer, audience, keySet)
+
+		if _, err := v.Verify(raw); err == nil {
+			t.Fatal("expected an error for an untrusted issuer, got nil")
+		}
+	})
+
+	t.Run("wrong audience", func(t *testing.T) {
+		raw, keySet := signedTestToken(t, issuer, "someone-else", time.Now().Add(time.Hour))
+		v := verifierForIssuer(issuer, audience, keySet)
+
+		if _, err := v.Verify(raw); err == nil {
+			t.Fatal("expected an error for the wrong audience, got nil")
+		}
+	})
+
+	t.Run("malformed token", func(t *testing.T) {
+		v := verifierForIssuer(issuer, audience, jose.JSONWebKeySet{})
+
+		if _, err := v.Verify("not-a-jwt"); err == nil {
+			t.Fatal("expected an error for a malformed token, got nil")
+		}
+	})
+
+	t.Run("no signing key matches", func(t *testing.T) {
+		raw, _ := signedTestToken(t, issuer, audience, time.Now().Add(time.Hour))
+		v := verifierForIssuer(issuer, audience, jose.JSONWebKeySet{})
+
+		if _, err := v.Verify(raw); err == nil {
+			t.Fatal("expected an error when no key matches, got nil")
+		}
+	})
+}
@@ -0,0 +1,117 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/open-policy-agent/opa/ast"
+	"github.com/open-policy-agent/opa/metrics"
+	"github.com/open-policy-agent/opa/rego"
+	"github.com/open-policy-agent/opa/storage"
+)
+
+const defaultRouteQuery = ""
+
+// PathMatch matches an incoming request by host and/or path prefix. An
+// empty field matches anything.
+type PathMatch struct {
+	Host       string `json:"host"`
+	PathPrefix string `json:"path-prefix"`
+}
+
+func (m PathMatch) matches(host, path string) bool {
+	if m.Host != "" && m.Host != host {
+		return false
+	}
+	if m.PathPrefix != "" && !strings.HasPrefix(path, m.PathPrefix) {
+		return false
+	}
+	return true
+}
+
+// RouteConfig matches an incoming request against a host/path prefix and
+// selects the Rego query to run for it, instead of the single global
+// Config.Query.
+type RouteConfig struct {
+	PathMatch
+	Query string `json:"query"`
+
+	parsedQuery ast.Body
+}
+
+// resolveQuery picks the Rego query to evaluate for req: the first
+// matching entry in cfg.Routes, falling back to the result of
+// cfg.RouteQuery (a distinguished Rego rule returning a query string) if
+// configured, and finally to cfg.Query. It shares txn and the metrics
+// collector with the caller's eval() so the routing rule itself goes
+// through the same prepared-query cache as the policy query it selects.
+func (p *envoyExtAuthzGrpcServer) resolveQuery(ctx context.Context, txn storage.Transaction, input ast.Value, host, path string, m metrics.Metrics) (ast.Body, error) {
+	for _, route := range p.cfg.Routes {
+		if !route.matches(host, path) {
+			continue
+		}
+		return route.parsedQuery, nil
+	}
+
+	if p.cfg.routeParsedQuery != nil {
+		query, err := p.evalRoute(ctx, txn, input, m)
+		if err != nil {
+			return nil, err
+		}
+		if query != nil {
+			return query, nil
+		}
+	}
+
+	return p.cfg.parsedQuery, nil
+}
+
+// evalRoute runs cfg.RouteQuery against input and parses its result (a
+// Rego rule such as data.istio.authz.route returning a query string)
+// into the query to evaluate for this request. The routing rule itself
+// is compiled once and cached in p.queryCache, just like the queries it
+// selects, so dynamic routing doesn't reintroduce a per-request
+// compilation cost.
+func (p *envoyExtAuthzGrpcServer) evalRoute(ctx context.Context, txn storage.Transaction, input ast.Value, m metrics.Metrics) (ast.Body, error) {
+	pq, err := p.preparedQuery(ctx, txn, p.cfg.routeParsedQuery, m)
+	if err != nil {
+		return nil, fmt.Errorf("route query: %w", err)
+	}
+
+	rs, err := pq.Eval(ctx,
+		rego.EvalParsedInput(input),
+		rego.EvalTransaction(txn),
+		rego.EvalMetrics(m),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("route query: %w", err)
+	}
+	if len(rs) == 0 {
+		return nil, nil
+	}
+
+	route, ok := rs[0].Expressions[0].Value.(string)
+	if !ok {
+		return nil, fmt.Errorf("route query must return a string, got %T", rs[0].Expressions[0].Value)
+	}
+
+	return ast.ParseBody(route)
+}
+
+// tokenRequired reports whether a request on host/path must carry a
+// verified bearer token. It lets a deployment that configures Issuers
+// still exempt specific routes (health checks, public endpoints) from
+// token verification, instead of requiring one on every single path.
+func (p *envoyExtAuthzGrpcServer) tokenRequired(host, path string) bool {
+	for _, exempt := range p.cfg.TokenExemptPaths {
+		if exempt.matches(host, path) {
+			return false
+		}
+	}
+	return true
+}
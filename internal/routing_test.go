@@ -0,0 +1,91 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+
+	"github.com/open-policy-agent/opa/ast"
+)
+
+func TestPathMatchMatches(t *testing.T) {
+	cases := []struct {
+		name  string
+		match PathMatch
+		host  string
+		path  string
+		want  bool
+	}{
+		{name: "empty matches anything", match: PathMatch{}, host: "a.example.com", path: "/foo", want: true},
+		{name: "host mismatch", match: PathMatch{Host: "a.example.com"}, host: "b.example.com", path: "/foo", want: false},
+		{name: "host match", match: PathMatch{Host: "a.example.com"}, host: "a.example.com", path: "/foo", want: true},
+		{name: "prefix mismatch", match: PathMatch{PathPrefix: "/admin"}, host: "a.example.com", path: "/foo", want: false},
+		{name: "prefix match", match: PathMatch{PathPrefix: "/admin"}, host: "a.example.com", path: "/admin/users", want: true},
+		{
+			name:  "host and prefix both required",
+			match: PathMatch{Host: "a.example.com", PathPrefix: "/admin"},
+			host:  "a.example.com",
+			path:  "/other",
+			want:  false,
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			if got := c.match.matches(c.host, c.path); got != c.want {
+				t.Errorf("matches(%q, %q) = %v, want %v", c.host, c.path, got, c.want)
+			}
+		})
+	}
+}
+
+func TestResolveQueryStaticRoutes(t *testing.T) {
+	defaultQuery := ast.MustParseBody("data.istio.authz.default_allow")
+	healthQuery := ast.MustParseBody("data.istio.authz.health_allow")
+
+	p := &envoyExtAuthzGrpcServer{cfg: Config{
+		parsedQuery: defaultQuery,
+		Routes: []RouteConfig{
+			{PathMatch: PathMatch{PathPrefix: "/healthz"}, parsedQuery: healthQuery},
+		},
+	}}
+
+	cases := []struct {
+		name string
+		host string
+		path string
+		want ast.Body
+	}{
+		{name: "matches a route", host: "a.example.com", path: "/healthz/live", want: healthQuery},
+		{name: "falls back to the default query", host: "a.example.com", path: "/other", want: defaultQuery},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			got, err := p.resolveQuery(nil, nil, nil, c.host, c.path, nil)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got.String() != c.want.String() {
+				t.Errorf("resolveQuery() = %v, want %v", got, c.want)
+			}
+		})
+	}
+}
+
+func TestTokenRequired(t *testing.T) {
+	p := &envoyExtAuthzGrpcServer{cfg: Config{
+		TokenExemptPaths: []PathMatch{
+			{PathPrefix: "/healthz"},
+		},
+	}}
+
+	if p.tokenRequired("a.example.com", "/healthz/live") {
+		t.Error("expected /healthz/live to be exempt from token verification")
+	}
+	if !p.tokenRequired("a.example.com", "/api/widgets") {
+		t.Error("expected /api/widgets to still require a token")
+	}
+}
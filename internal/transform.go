@@ -0,0 +1,158 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/service/auth/v2"
+)
+
+// Transform normalizes or enriches the Rego input map before it is
+// evaluated, given the raw CheckRequest Envoy sent. Transforms run in
+// the order configured and may look at (and overwrite) any part of
+// input, including fields earlier transforms added.
+type Transform func(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) error
+
+var transforms = map[string]Transform{}
+
+// RegisterTransform adds a named input transform that can be referenced
+// from Config.InputTransforms. It is the extension point for users who
+// need transforms beyond the built-in ones; call it from an init()
+// function before the plugin is loaded.
+func RegisterTransform(name string, t Transform) {
+	transforms[name] = t
+}
+
+func init() {
+	RegisterTransform("decode-body-base64", decodeBodyBase64Transform)
+	RegisterTransform("parse-body-json", parseBodyJSONTransform)
+	RegisterTransform("parse-body-form", parseBodyFormTransform)
+	RegisterTransform("parse-query", parseQueryTransform)
+}
+
+// applyTransforms runs the plugin's configured input transforms over
+// input in order, stopping at the first error.
+func (p *envoyExtAuthzGrpcServer) applyTransforms(input map[string]interface{}, req *ext_authz.CheckRequest) error {
+	for _, name := range p.cfg.InputTransforms {
+		t, ok := transforms[name]
+		if !ok {
+			return fmt.Errorf("unknown input transform %q", name)
+		}
+		if err := t(input, req, &p.cfg); err != nil {
+			return fmt.Errorf("input transform %q: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// requestBody returns the raw HTTP request body, enforcing
+// cfg.MaxBodyBytes when set.
+func requestBody(req *ext_authz.CheckRequest, cfg *Config) (string, error) {
+	body := req.GetAttributes().GetRequest().GetHttp().GetBody()
+	if cfg.MaxBodyBytes > 0 && int64(len(body)) > cfg.MaxBodyBytes {
+		return "", fmt.Errorf("request body exceeds max-body-bytes (%d > %d)", len(body), cfg.MaxBodyBytes)
+	}
+	return body, nil
+}
+
+// decodeBodyBase64Transform base64-decodes the body Envoy attaches to
+// attributes.request.http.body and stores the decoded text under
+// input.parsed_body, leaving it to a later transform (or Rego itself)
+// to interpret its structure.
+func decodeBodyBase64Transform(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) error {
+	body, err := requestBody(req, cfg)
+	if err != nil {
+		return err
+	}
+	if body == "" {
+		return nil
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return fmt.Errorf("decode base64 body: %w", err)
+	}
+
+	input["parsed_body"] = string(decoded)
+	return nil
+}
+
+// parseBodyJSONTransform parses input.parsed_body (falling back to the
+// raw, non-decoded body) as JSON, replacing it with the decoded value.
+func parseBodyJSONTransform(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) error {
+	body, err := bodyOrParsedBody(input, req, cfg)
+	if err != nil || body == "" {
+		return err
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal([]byte(body), &parsed); err != nil {
+		return fmt.Errorf("parse json body: %w", err)
+	}
+
+	input["parsed_body"] = parsed
+	return nil
+}
+
+// parseBodyFormTransform parses input.parsed_body (falling back to the
+// raw, non-decoded body) as application/x-www-form-urlencoded.
+func parseBodyFormTransform(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) error {
+	body, err := bodyOrParsedBody(input, req, cfg)
+	if err != nil || body == "" {
+		return err
+	}
+
+	values, err := url.ParseQuery(body)
+	if err != nil {
+		return fmt.Errorf("parse form body: %w", err)
+	}
+
+	input["parsed_body"] = valuesToInterface(values)
+	return nil
+}
+
+// parseQueryTransform splits and decodes the request's query string
+// into input.parsed_query.
+func parseQueryTransform(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) error {
+	path := req.GetAttributes().GetRequest().GetHttp().GetPath()
+
+	parts := strings.SplitN(path, "?", 2)
+	if len(parts) != 2 {
+		input["parsed_query"] = map[string]interface{}{}
+		return nil
+	}
+
+	values, err := url.ParseQuery(parts[1])
+	if err != nil {
+		return fmt.Errorf("parse query: %w", err)
+	}
+
+	input["parsed_query"] = valuesToInterface(values)
+	return nil
+}
+
+func bodyOrParsedBody(input map[string]interface{}, req *ext_authz.CheckRequest, cfg *Config) (string, error) {
+	if parsed, ok := input["parsed_body"].(string); ok {
+		return parsed, nil
+	}
+	return requestBody(req, cfg)
+}
+
+func valuesToInterface(values url.Values) map[string]interface{} {
+	out := make(map[string]interface{}, len(values))
+	for k, v := range values {
+		vs := make([]interface{}, len(v))
+		for i := range v {
+			vs[i] = v[i]
+		}
+		out[k] = vs
+	}
+	return out
+}
@@ -0,0 +1,179 @@
+// Copyright 2018 The OPA Authors.  All rights reserved.
+// Use of this source code is governed by an Apache2
+// license that can be found in the LICENSE file.
+
+package internal
+
+import (
+	"testing"
+
+	ext_authz "github.com/envoyproxy/go-control-plane/envoy/service/auth/v2"
+)
+
+func requestWithBody(body, path string) *ext_authz.CheckRequest {
+	return &ext_authz.CheckRequest{
+		Attributes: &ext_authz.AttributeContext{
+			Request: &ext_authz.AttributeContext_Request{
+				Http: &ext_authz.AttributeContext_HttpRequest{
+					Path: path,
+					Body: body,
+				},
+			},
+		},
+	}
+}
+
+func TestDecodeBodyBase64Transform(t *testing.T) {
+	input := map[string]interface{}{}
+	req := requestWithBody("aGVsbG8=", "/")
+
+	if err := decodeBodyBase64Transform(input, req, &Config{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if input["parsed_body"] != "hello" {
+		t.Errorf("parsed_body = %v, want %q", input["parsed_body"], "hello")
+	}
+}
+
+func TestDecodeBodyBase64TransformMalformed(t *testing.T) {
+	input := map[string]interface{}{}
+	req := requestWithBody("not-base64!!", "/")
+
+	if err := decodeBodyBase64Transform(input, req, &Config{}); err == nil {
+		t.Fatal("expected an error decoding a malformed base64 body")
+	}
+}
+
+func TestDecodeBodyBase64TransformEmpty(t *testing.T) {
+	input := map[string]interface{}{}
+	req := requestWithBody("", "/")
+
+	if err := decodeBodyBase64Transform(input, req, &Config{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, ok := input["parsed_body"]; ok {
+		t.Errorf("expected no parsed_body for an empty request body")
+	}
+}
+
+func TestParseBodyJSONTransform(t *testing.T) {
+	input := map[string]interface{}{"parsed_body": `{"foo":"bar"}`}
+	req := requestWithBody("", "/")
+
+	if err := parseBodyJSONTransform(input, req, &Config{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, ok := input["parsed_body"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("parsed_body = %#v, want a map", input["parsed_body"])
+	}
+	if parsed["foo"] != "bar" {
+		t.Errorf("parsed_body[foo] = %v, want %q", parsed["foo"], "bar")
+	}
+}
+
+func TestParseBodyJSONTransformMalformed(t *testing.T) {
+	input := map[string]interface{}{"parsed_body": `not json`}
+	req := requestWithBody("", "/")
+
+	if err := parseBodyJSONTransform(input, req, &Config{}); err == nil {
+		t.Fatal("expected an error parsing malformed JSON")
+	}
+}
+
+func TestParseBodyFormTransform(t *testing.T) {
+	input := map[string]interface{}{"parsed_body": "foo=bar&foo=baz"}
+	req := requestWithBody("", "/")
+
+	if err := parseBodyFormTransform(input, req, &Config{}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, ok := input["parsed_body"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("parsed_body = %#v, want a map", input["parsed_body"])
+	}
+	values, ok := parsed["foo"].([]interface{})
+	if !ok || len(values) != 2 {
+		t.Fatalf("parsed_body[foo] = %#v, want a 2-element slice", parsed["foo"])
+	}
+}
+
+func TestParseQueryTransform(t *testing.T) {
+	cases := []struct {
+		name string
+		path string
+		want map[string]interface{}
+	}{
+		{
+			name: "no query string",
+			path: "/foo",
+			want: map[string]interface{}{},
+		},
+		{
+			name: "single value",
+			path: "/foo?bar=baz",
+			want: map[string]interface{}{"bar": []interface{}{"baz"}},
+		},
+		{
+			name: "repeated value",
+			path: "/foo?bar=baz&bar=qux",
+			want: map[string]interface{}{"bar": []interface{}{"baz", "qux"}},
+		},
+	}
+
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			input := map[string]interface{}{}
+			req := requestWithBody("", c.path)
+
+			if err := parseQueryTransform(input, req, &Config{}); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			parsed, ok := input["parsed_query"].(map[string]interface{})
+			if !ok {
+				t.Fatalf("parsed_query = %#v, want a map", input["parsed_query"])
+			}
+			if len(parsed) != len(c.want) {
+				t.Fatalf("parsed_query = %#v, want %#v", parsed, c.want)
+			}
+			for k, want := range c.want {
+				got, ok := parsed[k].([]interface{})
+				if !ok || len(got) != len(want.([]interface{})) {
+					t.Errorf("parsed_query[%s] = %#v, want %#v", k, parsed[k], want)
+				}
+			}
+		})
+	}
+}
+
+func TestApplyTransformsUnknown(t *testing.T) {
+	p := &envoyExtAuthzGrpcServer{cfg: Config{InputTransforms: []string{"does-not-exist"}}}
+
+	if err := p.applyTransforms(map[string]interface{}{}, requestWithBody("", "/")); err == nil {
+		t.Fatal("expected an error for an unregistered transform")
+	}
+}
+
+func TestApplyTransformsOrder(t *testing.T) {
+	p := &envoyExtAuthzGrpcServer{cfg: Config{
+		InputTransforms: []string{"decode-body-base64", "parse-body-json"},
+	}}
+
+	input := map[string]interface{}{}
+	req := requestWithBody("eyJmb28iOiJiYXIifQ==", "/") // {"foo":"bar"}
+
+	if err := p.applyTransforms(input, req); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parsed, ok := input["parsed_body"].(map[string]interface{})
+	if !ok {
+		t.Fatalf("parsed_body = %#v, want a map", input["parsed_body"])
+	}
+	if parsed["foo"] != "bar" {
+		t.Errorf("parsed_body[foo] = %v, want %q", parsed["foo"], "bar")
+	}
+}